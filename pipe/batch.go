@@ -0,0 +1,127 @@
+package pipe
+
+import (
+	"context"
+	"time"
+
+	"github.com/soluto/dqd/metrics"
+	v1 "github.com/soluto/dqd/v1"
+)
+
+// BatchHandler is implemented by handlers that can process several messages
+// in a single call instead of one at a time. The returned slice must line up
+// positionally with the input messages; a nil entry means "no output" for
+// that message.
+type BatchHandler interface {
+	HandleBatch(ctx context.Context, messages []v1.Message) ([]*v1.RawMessage, error)
+}
+
+// batchConfig controls how readMessages groups incoming requestContexts
+// before handing them to a BatchHandler.
+type batchConfig struct {
+	maxBatchSize int
+	maxBatchWait time.Duration
+}
+
+// batcher accumulates requestContexts and flushes them to out either once
+// maxBatchSize is reached or maxBatchWait has elapsed since the first item
+// in the pending batch, whichever comes first. It runs on a single goroutine
+// with no semaphore of its own, so concurrency for batch mode is governed
+// entirely by cfg, not by readMessages' semaphore/AIMD controller.
+type batcher struct {
+	cfg         batchConfig
+	handler     BatchHandler
+	workerName  string
+	panicPolicy PanicPolicy
+	out         chan<- *requestContext
+	panics      chan<- error
+}
+
+func newBatcher(cfg batchConfig, handler BatchHandler, workerName string, panicPolicy PanicPolicy, out chan<- *requestContext, panics chan<- error) *batcher {
+	if cfg.maxBatchSize <= 0 {
+		cfg.maxBatchSize = 1
+	}
+	return &batcher{cfg: cfg, handler: handler, workerName: workerName, panicPolicy: panicPolicy, out: out, panics: panics}
+}
+
+// run consumes requestContexts from in until it's closed or ctx is done,
+// flushing completed batches to the handler as they fill up or time out.
+func (b *batcher) run(ctx context.Context, in <-chan *requestContext) {
+	pending := make([]*requestContext, 0, b.cfg.maxBatchSize)
+	var timer *time.Timer
+	var timerC <-chan time.Time
+
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		batch := pending
+		pending = make([]*requestContext, 0, b.cfg.maxBatchSize)
+		b.handle(ctx, batch)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			flush()
+			return
+		case r, ok := <-in:
+			if !ok {
+				flush()
+				return
+			}
+			if len(pending) == 0 && b.cfg.maxBatchWait > 0 {
+				timer = time.NewTimer(b.cfg.maxBatchWait)
+				timerC = timer.C
+			}
+			pending = append(pending, r)
+			if len(pending) >= b.cfg.maxBatchSize {
+				if timer != nil {
+					timer.Stop()
+					timerC = nil
+				}
+				flush()
+			}
+		case <-timerC:
+			timerC = nil
+			flush()
+		}
+	}
+}
+
+// handle invokes the BatchHandler over batch and fans the per-message
+// results back out as individual requestContexts, same as the single-message
+// path does. A panic in the handler is recovered and reported to every
+// message in the batch as an error, same as a returned error would be.
+func (b *batcher) handle(ctx context.Context, batch []*requestContext) {
+	messages := make([]v1.Message, len(batch))
+	for i, r := range batch {
+		messages[i] = r.Message()
+	}
+
+	results, err := func() (results []*v1.RawMessage, err error) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				metrics.WorkerPanicsCounter.WithLabelValues(b.workerName, "batch").Inc()
+				err = panicToError(rec)
+			}
+		}()
+		return b.handler.HandleBatch(ctx, messages)
+	}()
+
+	for i, r := range batch {
+		var result *v1.RawMessage
+		itemErr := err
+		if err == nil && i < len(results) {
+			result = results[i]
+		}
+		b.out <- r.WithResult(result, itemErr)
+	}
+
+	if _, isPanic := err.(*panicError); isPanic && b.panicPolicy != PanicPolicyContinue {
+		select {
+		case b.panics <- err:
+		default:
+		}
+	}
+}
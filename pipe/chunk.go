@@ -0,0 +1,216 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	v1 "github.com/soluto/dqd/v1"
+)
+
+const (
+	chunkGroupMetadataKey = "dqd-chunk-group"
+	chunkIndexMetadataKey = "dqd-chunk-index"
+	chunkTotalMetadataKey = "dqd-chunk-total"
+)
+
+// chunkConfig controls when outgoing RawMessages get split into chunks and
+// how long an incomplete group of incoming chunks is kept around before
+// being given up on.
+type chunkConfig struct {
+	maxMessageSize int
+	reassemblyTTL  time.Duration
+}
+
+// splitIntoChunks breaks m.Data into pieces no larger than cfg.maxMessageSize,
+// stamping each with a shared group id plus its index/total so the
+// reassembly buffer on the other end can put them back together in order.
+// Messages at or under the threshold are returned unchanged.
+func splitIntoChunks(cfg chunkConfig, m *v1.RawMessage) []*v1.RawMessage {
+	if cfg.maxMessageSize <= 0 || len(m.Data) <= cfg.maxMessageSize {
+		return []*v1.RawMessage{m}
+	}
+
+	group := uuid.New().String()
+	var chunks []*v1.RawMessage
+	for start := 0; start < len(m.Data); start += cfg.maxMessageSize {
+		end := start + cfg.maxMessageSize
+		if end > len(m.Data) {
+			end = len(m.Data)
+		}
+		meta := make(map[string]string, len(m.Metadata)+3)
+		for k, v := range m.Metadata {
+			meta[k] = v
+		}
+		meta[chunkGroupMetadataKey] = group
+		chunks = append(chunks, &v1.RawMessage{
+			Data:     m.Data[start:end],
+			Metadata: meta,
+		})
+	}
+	total := len(chunks)
+	for i, c := range chunks {
+		c.Metadata[chunkIndexMetadataKey] = fmt.Sprintf("%d", i)
+		c.Metadata[chunkTotalMetadataKey] = fmt.Sprintf("%d", total)
+	}
+	return chunks
+}
+
+// chunkGroup tracks the chunks seen so far for one group id.
+type chunkGroup struct {
+	total    int
+	received map[int]v1.Message
+	lastSeen time.Time
+}
+
+// reassemblyBuffer collects chunked messages by group id and yields a single
+// logical v1.Message once every chunk in the group has arrived. Groups that
+// go cfg.reassemblyTTL without receiving a new chunk are evicted and
+// dead-lettered via the given errorSource instead of blocking forever.
+type reassemblyBuffer struct {
+	cfg         chunkConfig
+	errorSource *v1.Source
+
+	mu     sync.Mutex
+	groups map[string]*chunkGroup
+}
+
+func newReassemblyBuffer(cfg chunkConfig, errorSource *v1.Source) *reassemblyBuffer {
+	return &reassemblyBuffer{
+		cfg:         cfg,
+		errorSource: errorSource,
+		groups:      make(map[string]*chunkGroup),
+	}
+}
+
+// offer records m as part of its chunk group (if it belongs to one) and
+// returns the reassembled message once the group is complete. Non-chunked
+// messages are passed straight through.
+func (b *reassemblyBuffer) offer(m v1.Message) (v1.Message, bool) {
+	group, ok := m.Metadata()[chunkGroupMetadataKey]
+	if !ok {
+		return m, true
+	}
+
+	index, total := chunkPosition(m)
+
+	b.mu.Lock()
+	g, ok := b.groups[group]
+	if !ok {
+		g = &chunkGroup{total: total, received: make(map[int]v1.Message)}
+		b.groups[group] = g
+	}
+	g.received[index] = m
+	g.lastSeen = time.Now()
+	complete := len(g.received) >= g.total
+	if complete {
+		delete(b.groups, group)
+	}
+	b.mu.Unlock()
+
+	if !complete {
+		return nil, false
+	}
+	return &reassembledMessage{Message: g.received[0], chunks: g.received, data: joinChunks(g)}, true
+}
+
+// evictExpired drops groups that haven't seen a new chunk within the TTL,
+// dead-lettering whatever partial data they accumulated.
+func (b *reassemblyBuffer) evictExpired(ctx context.Context) {
+	if b.cfg.reassemblyTTL <= 0 {
+		return
+	}
+
+	var expired []*chunkGroup
+	cutoff := time.Now().Add(-b.cfg.reassemblyTTL)
+	b.mu.Lock()
+	for group, g := range b.groups {
+		if g.lastSeen.Before(cutoff) {
+			expired = append(expired, g)
+			delete(b.groups, group)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, g := range expired {
+		if b.errorSource == nil {
+			continue
+		}
+		b.errorSource.Produce(ctx, &v1.RawMessage{Data: joinChunks(g), Metadata: chunkMetadata(g)})
+	}
+}
+
+// chunkMetadata returns the metadata carried by any one received chunk in g.
+// splitIntoChunks copies the original producer metadata onto every chunk, so
+// any one of them represents the whole group; this is the same metadata the
+// success path exposes via chunk 0's promoted Metadata() method.
+func chunkMetadata(g *chunkGroup) map[string]string {
+	for i := 0; i < g.total; i++ {
+		if c, ok := g.received[i]; ok {
+			return c.Metadata()
+		}
+	}
+	return nil
+}
+
+func chunkPosition(m v1.Message) (index, total int) {
+	fmt.Sscanf(m.Metadata()[chunkIndexMetadataKey], "%d", &index)
+	fmt.Sscanf(m.Metadata()[chunkTotalMetadataKey], "%d", &total)
+	return index, total
+}
+
+func joinChunks(g *chunkGroup) []byte {
+	var data []byte
+	for i := 0; i < g.total; i++ {
+		if c, ok := g.received[i]; ok {
+			data = append(data, c.Data()...)
+		}
+	}
+	return data
+}
+
+// reassembledMessage wraps every chunk v1.Message in a completed group, so
+// the logical message's own outcome - not just chunk 0's - decides what
+// happens to each physical chunk. Metadata() and any other promoted method
+// still read from chunk 0 (splitIntoChunks copies the same producer metadata
+// onto every chunk, so any one represents the whole group); Data() returns
+// the joined payload; Ack and Abort fan out to every chunk instead of just
+// chunk 0, since completing them up front - before the handler has even run
+// - would leave the rest permanently undelivered on success, or silently
+// lose them on a retry that can never gather the same group again.
+type reassembledMessage struct {
+	v1.Message
+	chunks map[int]v1.Message
+	data   []byte
+}
+
+func (r *reassembledMessage) Data() []byte {
+	return r.data
+}
+
+// Ack acks every chunk now that the logical message succeeded, returning
+// the first error encountered, if any, after attempting all of them.
+func (r *reassembledMessage) Ack() error {
+	var firstErr error
+	for _, c := range r.chunks {
+		if err := c.Ack(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Abort aborts every chunk so the whole group is handled together - the
+// same way a single unchunked message would be - instead of leaving all but
+// chunk 0 silently lost.
+func (r *reassembledMessage) Abort() bool {
+	handled := false
+	for _, c := range r.chunks {
+		if c.Abort() {
+			handled = true
+		}
+	}
+	return handled
+}
@@ -0,0 +1,145 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "github.com/soluto/dqd/v1"
+)
+
+type fakeMessage struct {
+	data     []byte
+	metadata map[string]string
+	acked    bool
+	ackErr   error
+	aborted  bool
+	abortRet bool
+}
+
+func (f *fakeMessage) Data() []byte                { return f.data }
+func (f *fakeMessage) Metadata() map[string]string { return f.metadata }
+func (f *fakeMessage) Ack() error                  { f.acked = true; return f.ackErr }
+func (f *fakeMessage) Abort() bool                 { f.aborted = true; return f.abortRet }
+
+func chunkMessage(group string, index, total int, data string) *fakeMessage {
+	return &fakeMessage{
+		data: []byte(data),
+		metadata: map[string]string{
+			chunkGroupMetadataKey: group,
+			chunkIndexMetadataKey: fmt.Sprintf("%d", index),
+			chunkTotalMetadataKey: fmt.Sprintf("%d", total),
+		},
+	}
+}
+
+func TestReassemblyBuffer_PassesThroughNonChunkedMessages(t *testing.T) {
+	b := newReassemblyBuffer(chunkConfig{}, nil)
+	m := &fakeMessage{data: []byte("hello")}
+
+	got, ok := b.offer(m)
+	if !ok || got != m {
+		t.Fatalf("expected non-chunked message to pass through unchanged, got %v, %v", got, ok)
+	}
+}
+
+func TestReassemblyBuffer_IncompleteUntilAllChunksArrive(t *testing.T) {
+	b := newReassemblyBuffer(chunkConfig{}, nil)
+
+	if _, ok := b.offer(chunkMessage("g1", 0, 2, "ab")); ok {
+		t.Fatal("expected group to be incomplete after the first of two chunks")
+	}
+
+	got, ok := b.offer(chunkMessage("g1", 1, 2, "cd"))
+	if !ok {
+		t.Fatal("expected group to complete once every chunk has arrived")
+	}
+	if string(got.Data()) != "abcd" {
+		t.Fatalf("expected joined data \"abcd\", got %q", got.Data())
+	}
+}
+
+func TestReassemblyBuffer_DoesNotAckChunksUpFrontOnCompletion(t *testing.T) {
+	b := newReassemblyBuffer(chunkConfig{}, nil)
+	c0 := chunkMessage("g1", 0, 2, "ab")
+	c1 := chunkMessage("g1", 1, 2, "cd")
+	b.offer(c0)
+	b.offer(c1)
+
+	if c0.acked || c1.acked {
+		t.Fatal("completing a group must not ack any chunk before the handler has run - that's what caused lost chunks on a later Abort")
+	}
+}
+
+func TestReassembledMessage_AckFansOutToEveryChunk(t *testing.T) {
+	b := newReassemblyBuffer(chunkConfig{}, nil)
+	c0 := chunkMessage("g1", 0, 2, "ab")
+	c1 := chunkMessage("g1", 1, 2, "cd")
+	b.offer(c0)
+	got, _ := b.offer(c1)
+
+	if err := got.Ack(); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	if !c0.acked || !c1.acked {
+		t.Fatal("expected Ack on the reassembled message to ack every chunk in the group")
+	}
+}
+
+func TestReassembledMessage_AbortFansOutToEveryChunk(t *testing.T) {
+	b := newReassemblyBuffer(chunkConfig{}, nil)
+	c0 := chunkMessage("g1", 0, 2, "ab")
+	c1 := chunkMessage("g1", 1, 2, "cd")
+	c1.abortRet = true
+	b.offer(c0)
+	got, _ := b.offer(c1)
+
+	if handled := got.Abort(); !handled {
+		t.Fatal("expected Abort to report handled when any chunk reports handled")
+	}
+	if !c0.aborted || !c1.aborted {
+		t.Fatal("expected Abort on the reassembled message to abort every chunk in the group, not just chunk 0")
+	}
+}
+
+func TestChunkMetadata_ReturnsAReceivedChunksMetadata(t *testing.T) {
+	c0 := chunkMessage("g1", 0, 1, "ab")
+	c0.metadata["orderId"] = "42"
+	g := &chunkGroup{total: 1, received: map[int]v1.Message{0: c0}}
+
+	meta := chunkMetadata(g)
+	if meta["orderId"] != "42" {
+		t.Fatalf("expected dead-lettered metadata to carry the producer's original keys, got %v", meta)
+	}
+}
+
+func TestReassemblyBuffer_EvictExpiredDropsStaleGroups(t *testing.T) {
+	b := newReassemblyBuffer(chunkConfig{reassemblyTTL: time.Millisecond}, nil)
+	b.offer(chunkMessage("g1", 0, 2, "ab"))
+
+	b.mu.Lock()
+	b.groups["g1"].lastSeen = time.Now().Add(-time.Hour)
+	b.mu.Unlock()
+
+	b.evictExpired(context.Background())
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.groups["g1"]; ok {
+		t.Fatal("expected evictExpired to drop a group that hasn't seen a chunk within the TTL")
+	}
+}
+
+func TestReassemblyBuffer_EvictExpiredLeavesFreshGroups(t *testing.T) {
+	b := newReassemblyBuffer(chunkConfig{reassemblyTTL: time.Hour}, nil)
+	b.offer(chunkMessage("g1", 0, 2, "ab"))
+
+	b.evictExpired(context.Background())
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.groups["g1"]; !ok {
+		t.Fatal("expected a group within the TTL to survive evictExpired")
+	}
+}
@@ -0,0 +1,111 @@
+package concurrency
+
+import "time"
+
+// AIMD is a latency- and error-aware additive-increase/multiplicative-decrease
+// controller: it backs off by a multiplicative factor as soon as errors or
+// tail latency regress, and otherwise grows the limit by a fixed step while
+// throughput is at or above its EWMA (holding it steady when throughput
+// dips), smoothing throughput through that EWMA to stay stable under noise.
+type AIMD struct {
+	// AdditiveIncrease is how much to grow the limit by per window when
+	// throughput is improving and latency is stable. Defaults to 1.
+	AdditiveIncrease int64
+	// MultiplicativeDecreaseFactor scales the limit down when backing off,
+	// e.g. 0.7 cuts it by 30%. Defaults to 0.7.
+	MultiplicativeDecreaseFactor float64
+	// ErrorRatioThreshold is the error ratio above which the controller
+	// backs off regardless of latency. Defaults to 0.05.
+	ErrorRatioThreshold float64
+	// LatencyRegressionThreshold is how much P95Latency is allowed to grow
+	// over the adaptive latency baseline, as a fraction, before backing off.
+	// Defaults to 0.5 (50% worse than baseline).
+	LatencyRegressionThreshold float64
+	// RateEWMAAlpha is the smoothing factor (0-1) applied to the processed
+	// rate; higher reacts faster, lower is smoother. Defaults to 0.3.
+	RateEWMAAlpha float64
+	// BaselineLatencyEWMAAlpha is the smoothing factor (0-1) applied to the
+	// adaptive latency baseline used for regression detection; lower tracks
+	// drift more slowly, so a single slow window can't pull the baseline up
+	// and mask a real regression. Defaults to 0.05.
+	BaselineLatencyEWMAAlpha float64
+
+	rateEWMA float64
+	hasRate  bool
+
+	baselineLatencyEWMA float64
+	hasBaseline         bool
+}
+
+func (a *AIMD) Next(stats Stats) Decision {
+	alpha := a.RateEWMAAlpha
+	if alpha <= 0 {
+		alpha = 0.3
+	}
+	rate := float64(stats.Processed)
+	if !a.hasRate {
+		a.rateEWMA = rate
+		a.hasRate = true
+	} else {
+		a.rateEWMA = alpha*rate + (1-alpha)*a.rateEWMA
+	}
+
+	errThreshold := a.ErrorRatioThreshold
+	if errThreshold <= 0 {
+		errThreshold = 0.05
+	}
+	if stats.ErrorRatio > errThreshold {
+		return a.backOff(stats, "downscale-errors")
+	}
+
+	latencyThreshold := a.LatencyRegressionThreshold
+	if latencyThreshold <= 0 {
+		latencyThreshold = 0.5
+	}
+	if a.hasBaseline && a.baselineLatencyEWMA > 0 {
+		regression := (float64(stats.P95Latency) - a.baselineLatencyEWMA) / a.baselineLatencyEWMA
+		if regression > latencyThreshold {
+			return a.backOff(stats, "downscale-latency")
+		}
+	}
+	a.updateBaseline(stats.P95Latency)
+
+	if rate >= a.rateEWMA {
+		step := a.AdditiveIncrease
+		if step <= 0 {
+			step = 1
+		}
+		return Decision{Concurrency: stats.Current + step, Reason: "upscale"}
+	}
+
+	return Decision{Concurrency: stats.Current, Reason: "steady"}
+}
+
+// updateBaseline folds the latest P95Latency into the adaptive baseline.
+// It's only reached for windows that didn't back off, so one bad sample
+// can't pull the baseline up with it - moving it takes sustained healthy
+// latency, which is what keeps the regression check meaningful over time.
+func (a *AIMD) updateBaseline(p95 time.Duration) {
+	beta := a.BaselineLatencyEWMAAlpha
+	if beta <= 0 {
+		beta = 0.05
+	}
+	if !a.hasBaseline {
+		a.baselineLatencyEWMA = float64(p95)
+		a.hasBaseline = true
+		return
+	}
+	a.baselineLatencyEWMA = beta*float64(p95) + (1-beta)*a.baselineLatencyEWMA
+}
+
+func (a *AIMD) backOff(stats Stats, reason string) Decision {
+	factor := a.MultiplicativeDecreaseFactor
+	if factor <= 0 || factor >= 1 {
+		factor = 0.7
+	}
+	next := int64(float64(stats.Current) * factor)
+	if next < stats.Min {
+		next = stats.Min
+	}
+	return Decision{Concurrency: next, Reason: reason}
+}
@@ -0,0 +1,60 @@
+package concurrency
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAIMD_UpscalesWhenHealthy(t *testing.T) {
+	a := &AIMD{AdditiveIncrease: 2}
+	decision := a.Next(Stats{Current: 4, Min: 1, Processed: 10, P95Latency: 10 * time.Millisecond})
+	if decision.Reason != "upscale" {
+		t.Fatalf("expected upscale, got %q", decision.Reason)
+	}
+	if decision.Concurrency != 6 {
+		t.Fatalf("expected concurrency 6, got %d", decision.Concurrency)
+	}
+}
+
+func TestAIMD_BacksOffOnErrorRatio(t *testing.T) {
+	a := &AIMD{ErrorRatioThreshold: 0.1, MultiplicativeDecreaseFactor: 0.5}
+	decision := a.Next(Stats{Current: 10, Min: 1, Processed: 10, ErrorRatio: 0.2})
+	if decision.Reason != "downscale-errors" {
+		t.Fatalf("expected downscale-errors, got %q", decision.Reason)
+	}
+	if decision.Concurrency != 5 {
+		t.Fatalf("expected concurrency 5, got %d", decision.Concurrency)
+	}
+}
+
+func TestAIMD_BaselineSurvivesASingleBadWindow(t *testing.T) {
+	a := &AIMD{LatencyRegressionThreshold: 0.2, BaselineLatencyEWMAAlpha: 0.5}
+
+	// A few healthy windows establish a baseline around 10ms.
+	for i := 0; i < 5; i++ {
+		a.Next(Stats{Current: 4, Min: 1, Processed: 10, P95Latency: 10 * time.Millisecond})
+	}
+
+	// One bad window backs off, but must not be folded into the baseline -
+	// otherwise the baseline would rise toward 40ms and hide a later, real
+	// regression against the healthy 10ms.
+	decision := a.Next(Stats{Current: 4, Min: 1, Processed: 10, P95Latency: 40 * time.Millisecond})
+	if decision.Reason != "downscale-latency" {
+		t.Fatalf("expected downscale-latency, got %q", decision.Reason)
+	}
+
+	// 13ms is 30% over the 10ms baseline, past the 0.2 threshold, so it is
+	// only flagged if the bad window left the baseline where it was.
+	decision = a.Next(Stats{Current: 4, Min: 1, Processed: 10, P95Latency: 13 * time.Millisecond})
+	if decision.Reason != "downscale-latency" {
+		t.Fatalf("baseline absorbed the single bad window, got %q", decision.Reason)
+	}
+}
+
+func TestAIMD_BackOffRespectsMin(t *testing.T) {
+	a := &AIMD{MultiplicativeDecreaseFactor: 0.1}
+	decision := a.backOff(Stats{Current: 10, Min: 5}, "downscale-errors")
+	if decision.Concurrency != 5 {
+		t.Fatalf("expected concurrency floored at Min=5, got %d", decision.Concurrency)
+	}
+}
@@ -0,0 +1,37 @@
+// Package concurrency provides pluggable strategies for deciding how many
+// messages a pipe.Worker should process in parallel.
+package concurrency
+
+import "time"
+
+// Stats summarizes one measurement window's worth of throughput and latency
+// data for a Controller to act on.
+type Stats struct {
+	// Current is the concurrency limit in effect during this window.
+	Current int64
+	// Min is the floor the controller must not decrease below.
+	Min int64
+	// Processed is the number of messages completed during this window.
+	Processed int64
+	// ErrorRatio is the fraction (0-1) of Processed that errored.
+	ErrorRatio float64
+	// P95Latency is the 95th-percentile handler latency observed this window.
+	P95Latency time.Duration
+}
+
+// Decision is the outcome of one Controller.Next call.
+type Decision struct {
+	// Concurrency is the new limit the worker should apply.
+	Concurrency int64
+	// Reason is a short, stable label explaining the decision (e.g.
+	// "upscale", "downscale-latency", "downscale-errors", "steady") for
+	// logging and metrics.
+	Reason string
+}
+
+// Controller decides the next concurrency limit given the latest window's
+// Stats. Implementations must be safe for use from a single goroutine per
+// worker; the worker does not call Next concurrently.
+type Controller interface {
+	Next(stats Stats) Decision
+}
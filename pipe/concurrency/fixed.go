@@ -0,0 +1,11 @@
+package concurrency
+
+// Fixed is a Controller that never changes the concurrency limit, matching
+// the behaviour of a worker configured with a static rate.
+type Fixed struct {
+	Concurrency int64
+}
+
+func (f Fixed) Next(Stats) Decision {
+	return Decision{Concurrency: f.Concurrency, Reason: "fixed"}
+}
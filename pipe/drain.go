@@ -0,0 +1,34 @@
+package pipe
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errDrainTimeout is joined into a loop's returned error when waitWithTimeout
+// gives up before its WaitGroup finished, so callers can tell "drained
+// cleanly" apart from "gave up, something may still be running" and act
+// accordingly (e.g. not closing a channel a straggler might still send on).
+var errDrainTimeout = errors.New("pipe: timed out waiting for in-flight work to drain")
+
+// waitWithTimeout waits for wg to complete, giving up after timeout. It
+// reports whether wg finished in time. A timed-out call leaks the internal
+// wait goroutine until wg eventually completes, which is preferable to
+// blocking shutdown indefinitely.
+func waitWithTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
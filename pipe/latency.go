@@ -0,0 +1,58 @@
+package pipe
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyWindow is a fixed-capacity ring buffer of recent handler durations,
+// used to estimate p95 latency for the concurrency controller without
+// keeping an unbounded history.
+type latencyWindow struct {
+	mu     sync.Mutex
+	values []time.Duration
+	cap    int
+	next   int
+	filled bool
+}
+
+func newLatencyWindow(capacity int) *latencyWindow {
+	if capacity <= 0 {
+		capacity = 256
+	}
+	return &latencyWindow{values: make([]time.Duration, capacity), cap: capacity}
+}
+
+func (w *latencyWindow) record(d time.Duration) {
+	w.mu.Lock()
+	w.values[w.next] = d
+	w.next = (w.next + 1) % w.cap
+	if w.next == 0 {
+		w.filled = true
+	}
+	w.mu.Unlock()
+}
+
+// p95 returns the 95th-percentile duration currently in the window, or 0 if
+// no samples have been recorded yet.
+func (w *latencyWindow) p95() time.Duration {
+	w.mu.Lock()
+	n := w.next
+	if w.filled {
+		n = w.cap
+	}
+	samples := make([]time.Duration, n)
+	copy(samples, w.values[:n])
+	w.mu.Unlock()
+
+	if n == 0 {
+		return 0
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	idx := int(float64(n) * 0.95)
+	if idx >= n {
+		idx = n - 1
+	}
+	return samples[idx]
+}
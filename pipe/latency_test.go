@@ -0,0 +1,34 @@
+package pipe
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyWindow_P95EmptyIsZero(t *testing.T) {
+	w := newLatencyWindow(10)
+	if got := w.p95(); got != 0 {
+		t.Fatalf("expected 0 for an empty window, got %s", got)
+	}
+}
+
+func TestLatencyWindow_P95(t *testing.T) {
+	w := newLatencyWindow(100)
+	for i := 1; i <= 100; i++ {
+		w.record(time.Duration(i) * time.Millisecond)
+	}
+	if got := w.p95(); got != 96*time.Millisecond {
+		t.Fatalf("expected 96ms, got %s", got)
+	}
+}
+
+func TestLatencyWindow_WrapsAtCapacity(t *testing.T) {
+	w := newLatencyWindow(3)
+	for _, d := range []time.Duration{1, 2, 3, 100, 200} {
+		w.record(d * time.Millisecond)
+	}
+	// Capacity 3, so only the last 3 recordings (3ms, 100ms, 200ms) remain.
+	if got := w.p95(); got != 200*time.Millisecond {
+		t.Fatalf("expected the window to have dropped the oldest samples, got %s", got)
+	}
+}
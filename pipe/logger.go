@@ -0,0 +1,51 @@
+package pipe
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+type correlationIDKeyType struct{}
+
+var correlationIDKey correlationIDKeyType
+
+// withCorrelationID returns a copy of ctx carrying a fresh correlation ID,
+// so every log line produced while handling the message it's attached to
+// can be traced back to it.
+func withCorrelationID(ctx context.Context) context.Context {
+	return context.WithValue(ctx, correlationIDKey, uuid.New().String())
+}
+
+// correlationID extracts the correlation ID stashed by withCorrelationID, if
+// any. requestContext forwards Value() to the context it wraps, so this
+// works transparently whether ctx is the raw context.Context or a
+// *requestContext built from it.
+func correlationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey).(string)
+	return id
+}
+
+// log returns the logger to use: the one configured via WithLogger, or the
+// package default if none was set.
+func (w *Worker) log() zerolog.Logger {
+	if w.logger != nil {
+		return *w.logger
+	}
+	return logger
+}
+
+// loggerFor returns w.log() enriched with the worker name, source name (if
+// any) and the correlation ID carried by ctx (if any), so every log line a
+// worker emits can be attributed to it.
+func (w *Worker) loggerFor(ctx context.Context, source string) zerolog.Logger {
+	l := w.log().With().Str("worker", w.name)
+	if source != "" {
+		l = l.Str("source", source)
+	}
+	if id := correlationID(ctx); id != "" {
+		l = l.Str("correlationId", id)
+	}
+	return l.Logger()
+}
@@ -0,0 +1,69 @@
+package pipe
+
+import (
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/soluto/dqd/pipe/concurrency"
+)
+
+// WithLogger overrides the logger a worker uses for every log line it
+// emits, in place of the package default. Useful for embedding applications
+// that want their own logging backend, or tests asserting on log output
+// per-worker instead of globally.
+func WithLogger(l zerolog.Logger) func(*Worker) {
+	return func(w *Worker) {
+		w.logger = &l
+	}
+}
+
+// WithConcurrencyController overrides the strategy used to adjust
+// concurrency between dynamicRateBatchWindow windows. Defaults to an
+// unconfigured *concurrency.AIMD when left unset.
+func WithConcurrencyController(controller concurrency.Controller) func(*Worker) {
+	return func(w *Worker) {
+		w.concurrencyController = controller
+	}
+}
+
+// WithBatchHandler switches the worker to batch mode: instead of calling
+// handler.Handle per message, messages are grouped and handed to
+// handler.HandleBatch, flushing once maxBatchSize messages are pending or
+// maxBatchWait has elapsed since the first message in the batch, whichever
+// happens first. Batch mode bypasses the semaphore and AIMD controller used
+// in single-message mode - throughput is governed entirely by maxBatchSize
+// and maxBatchWait, not by WithConcurrencyController or the concurrency
+// options on Worker.
+func WithBatchHandler(handler BatchHandler, maxBatchSize int, maxBatchWait time.Duration) func(*Worker) {
+	return func(w *Worker) {
+		w.batchHandler = handler
+		w.batchConfig = batchConfig{maxBatchSize: maxBatchSize, maxBatchWait: maxBatchWait}
+	}
+}
+
+// WithShutdownTimeout bounds how long Worker.Start (or Worker.Stop) waits
+// for in-flight messages to drain and results to flush once shutdown
+// begins, before giving up and returning a timeout error. Defaults to 30s.
+func WithShutdownTimeout(timeout time.Duration) func(*Worker) {
+	return func(w *Worker) {
+		w.shutdownTimeout = timeout
+	}
+}
+
+// WithPanicPolicy controls what a worker does after recovering from a panic
+// in a handler or output call. Defaults to PanicPolicyContinue.
+func WithPanicPolicy(policy PanicPolicy) func(*Worker) {
+	return func(w *Worker) {
+		w.panicPolicy = policy
+	}
+}
+
+// WithChunking enables splitting of outgoing RawMessages larger than
+// maxMessageSize into ordered chunks, and reassembly of incoming chunks on
+// the read side. Incomplete chunk groups that have gone reassemblyTTL
+// without a new chunk are dead-lettered via the worker's error source.
+func WithChunking(maxMessageSize int, reassemblyTTL time.Duration) func(*Worker) {
+	return func(w *Worker) {
+		w.chunkConfig = chunkConfig{maxMessageSize: maxMessageSize, reassemblyTTL: reassemblyTTL}
+	}
+}
@@ -0,0 +1,43 @@
+package pipe
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicPolicy controls what a worker does after recovering from a panic in
+// a handler or output call.
+type PanicPolicy int
+
+const (
+	// PanicPolicyContinue recovers the panic, routes the offending message
+	// to the error source, and keeps the worker running unaffected. This is
+	// the default.
+	PanicPolicyContinue PanicPolicy = iota
+	// PanicPolicyStopWorker recovers the panic, routes the offending
+	// message, and restarts the worker's read/result loops with
+	// exponential backoff.
+	PanicPolicyStopWorker
+	// PanicPolicyStopPipe recovers the panic, routes the offending message,
+	// and stops the whole pipe by returning the panic as Worker.Start's
+	// error.
+	PanicPolicyStopPipe
+)
+
+// panicError wraps a recovered panic value together with the stack trace
+// captured at the moment of recovery.
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v\n%s", e.value, e.stack)
+}
+
+// panicToError converts a value recovered from a panic into an error,
+// capturing a stack trace. Must be called from within the deferred function
+// that called recover(), while the stack is still unwinding.
+func panicToError(r interface{}) error {
+	return &panicError{value: r, stack: debug.Stack()}
+}
@@ -0,0 +1,95 @@
+package pipe
+
+import (
+	"context"
+	"sync"
+)
+
+// semaphore is a resizable counting semaphore used to bound how many
+// messages a worker processes concurrently. Unlike the busy-wait it
+// replaces, acquire/release are O(1) and acquire unblocks as soon as either
+// a slot frees up or ctx is done.
+type semaphore struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int64
+	inUse int64
+}
+
+func newSemaphore(limit int64) *semaphore {
+	s := &semaphore{limit: limit}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// setLimit changes the number of slots available, waking any acquirers that
+// may now be able to proceed.
+func (s *semaphore) setLimit(limit int64) {
+	s.mu.Lock()
+	s.limit = limit
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+func (s *semaphore) Limit() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limit
+}
+
+// acquire blocks until a slot is available or ctx is done, whichever comes
+// first.
+func (s *semaphore) acquire(ctx context.Context) error {
+	if ctx.Done() != nil {
+		stopWatching := s.watch(ctx)
+		defer stopWatching()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.inUse >= s.limit {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.cond.Wait()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.inUse++
+	return nil
+}
+
+func (s *semaphore) release() {
+	s.mu.Lock()
+	s.inUse--
+	s.mu.Unlock()
+	s.cond.Signal()
+}
+
+// watch wakes up any blocked acquirer as soon as ctx is done, since
+// sync.Cond.Wait can't select on a context by itself. The returned func
+// must be called once the caller is done waiting, to stop the goroutine.
+func (s *semaphore) watch(ctx context.Context) (stop func()) {
+	stopped := make(chan struct{})
+	var stopOnce sync.Once
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Taking mu orders the broadcast after any acquirer that has
+			// checked ctx.Err but not yet entered cond.Wait.
+			s.mu.Lock()
+			s.cond.Broadcast()
+			s.mu.Unlock()
+		case <-stopped:
+		}
+	}()
+	return func() { stopOnce.Do(func() { close(stopped) }) }
+}
+
+// inFlight reports how many slots are currently taken.
+func (s *semaphore) inFlight() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inUse
+}
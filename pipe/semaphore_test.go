@@ -0,0 +1,92 @@
+package pipe
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSemaphore_AcquireReleaseRoundTrip(t *testing.T) {
+	s := newSemaphore(1)
+	if err := s.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if got := s.inFlight(); got != 1 {
+		t.Fatalf("expected inFlight 1, got %d", got)
+	}
+	s.release()
+	if got := s.inFlight(); got != 0 {
+		t.Fatalf("expected inFlight 0 after release, got %d", got)
+	}
+}
+
+func TestSemaphore_AcquireBlocksUntilSlotFrees(t *testing.T) {
+	s := newSemaphore(1)
+	if err := s.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		if err := s.acquire(context.Background()); err != nil {
+			return
+		}
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second acquire returned before a slot was released")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	s.release()
+
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("second acquire never unblocked after release")
+	}
+}
+
+func TestSemaphore_AcquireRespectsContextCancellation(t *testing.T) {
+	s := newSemaphore(1)
+	if err := s.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.acquire(ctx) }()
+	cancel()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected acquire to return an error on cancellation")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acquire did not return after context cancellation")
+	}
+}
+
+func TestSemaphore_SetLimitWakesBlockedAcquirers(t *testing.T) {
+	s := newSemaphore(1)
+	if err := s.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.acquire(context.Background()) }()
+
+	s.setLimit(2)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("raising the limit did not wake the blocked acquirer")
+	}
+}
@@ -2,11 +2,15 @@ package pipe
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/soluto/dqd/metrics"
+	"github.com/soluto/dqd/pipe/concurrency"
 	v1 "github.com/soluto/dqd/v1"
 )
 
@@ -14,10 +18,10 @@ func (w *Worker) handleErrorRequest(ctx *requestContext, err error) {
 	m := ctx.Message()
 	if !m.Abort() {
 		if w.writeToErrorSource {
-			err = w.errorSource.Produce(ctx, &v1.RawMessage{m.Data()})
+			err = w.errorSource.Produce(ctx, &v1.RawMessage{Data: m.Data()})
 		}
 		if err != nil {
-			logger.Error().Err(err).Msg("Failed to process message")
+			w.loggerFor(ctx, ctx.Source()).Error().Err(err).Msg("Failed to process message")
 		}
 	}
 }
@@ -31,155 +35,426 @@ func (w *Worker) handleRequest(ctx *requestContext) (_ *v1.RawMessage, err error
 	return w.handler.Handle(ctx, ctx.Message())
 }
 
-func (w *Worker) handleResults(ctx context.Context, results chan *requestContext) error {
-	done := make(chan error)
-	defer close(done)
-	for reqCtx := range results {
+func (w *Worker) handleResults(ctx context.Context, results chan *requestContext) (err error) {
+	panics := make(chan error, 1)
+	var processing sync.WaitGroup
+	defer func() {
+		if !waitWithTimeout(&processing, w.shutdownTimeout) {
+			err = errors.Join(err, errDrainTimeout)
+		}
+	}()
+
+	flushTimeout := w.shutdownTimeout
+	if flushTimeout <= 0 {
+		flushTimeout = 30 * time.Second
+	}
+	ctxDone := ctx.Done()
+	var flushDeadline <-chan time.Time
+	for {
 		select {
-		case err := <-done:
+		case err := <-panics:
 			return err
-		case <-ctx.Done():
+		case <-ctxDone:
+			// Keep flushing results until Start closes the channel once
+			// readMessages has no sender left, bounded by the shutdown timeout.
+			ctxDone = nil
+			flushDeadline = time.After(flushTimeout)
+		case <-flushDeadline:
 			return nil
-		default:
-		}
-		go func() {
-			m, err := reqCtx.Result()
-			defer func() {
-				t := float64(time.Since(reqCtx.StartTime())) / float64(time.Second)
-				metrics.PipeProcessingMessagesHistogram.WithLabelValues(w.name, reqCtx.Source(), strconv.FormatBool(err != nil)).Observe(t)
-			}()
-			if err != nil {
-				w.handleErrorRequest(reqCtx, err)
-			} else if m != nil && w.output != nil {
-				err := w.output.Produce(reqCtx, m)
+		case reqCtx, ok := <-results:
+			if !ok {
+				return nil
+			}
+			processing.Add(1)
+			go func() {
+				defer processing.Done()
+				defer func() {
+					if rec := recover(); rec != nil {
+						err := panicToError(rec)
+						metrics.WorkerPanicsCounter.WithLabelValues(w.name, reqCtx.Source()).Inc()
+						w.handleErrorRequest(reqCtx, err)
+						if w.panicPolicy != PanicPolicyContinue {
+							select {
+							case panics <- err:
+							default:
+							}
+						}
+					}
+				}()
+				m, err := reqCtx.Result()
+				defer func() {
+					t := float64(time.Since(reqCtx.StartTime())) / float64(time.Second)
+					metrics.PipeProcessingMessagesHistogram.WithLabelValues(w.name, reqCtx.Source(), strconv.FormatBool(err != nil)).Observe(t)
+				}()
 				if err != nil {
 					w.handleErrorRequest(reqCtx, err)
+				} else if m != nil && w.output != nil {
+					for _, chunk := range splitIntoChunks(w.chunkConfig, m) {
+						if err := w.output.Produce(reqCtx, chunk); err != nil {
+							w.handleErrorRequest(reqCtx, err)
+							break
+						}
+					}
 				}
-			}
 
-		}()
+			}()
+		}
 	}
-	return nil
 }
 
-func (w *Worker) readMessages(ctx context.Context, messages chan *requestContext, results chan *requestContext) error {
+func (w *Worker) readMessages(ctx context.Context, messages chan *requestContext, results chan *requestContext) (err error) {
 	maxConcurrencyGauge := metrics.WorkerMaxConcurrencyGauge.WithLabelValues(w.name)
 	batchSizeGauge := metrics.WorkerBatchSizeGauge.WithLabelValues(w.name)
 
-	var count, lastBatch int64
-	maxItems := int64(w.concurrencyStartingPoint)
+	var lastBatch, lastErrors int64
+	sem := newSemaphore(int64(w.concurrencyStartingPoint))
 	minConcurrency := int64(w.minConcurrency)
-	defer close(messages)
+	latency := newLatencyWindow(w.latencyWindowSize)
+	latencyEWMAGauge := metrics.WorkerLatencyEWMAGauge.WithLabelValues(w.name)
+	decisionReasonGauge := metrics.WorkerConcurrencyDecisionReasonGauge
 
-	maxConcurrencyGauge.Set(float64(maxItems))
+	maxConcurrencyGauge.Set(float64(sem.Limit()))
 
-	// Handle messages
-	go func() {
-		for message := range messages {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
-			for count >= maxItems {
-				time.Sleep(10 * time.Millisecond)
-			}
+	panics := make(chan error, 1)
+	// wg tracks every goroutine readMessages spawns that can still send on
+	// messages or results, so Start only closes those channels once wg.Wait
+	// confirms none of them are left sending - closing earlier risks a send
+	// on a closed channel, closing never risks leaking them instead.
+	var wg sync.WaitGroup
+	defer func() {
+		if !waitWithTimeout(&wg, w.shutdownTimeout) {
+			err = errors.Join(err, errDrainTimeout)
+		}
+	}()
 
-			atomic.AddInt64(&count, 1)
+	// attemptCtx scopes every goroutine below - the batch runner, the
+	// per-message dispatch loop, the AIMD tuner, the chunk-eviction ticker,
+	// and the per-source consumers - to this single call. ctx itself spans
+	// every attempt a PanicPolicyStopWorker restart makes, so keying any of
+	// them off ctx would leave the previous attempt's copies running forever
+	// (and duplicating source consumption) each time superviseLoop calls
+	// readMessages again after a panic.
+	attemptCtx, cancelAttempt := context.WithCancel(ctx)
+	defer cancelAttempt()
 
-			go func(r *requestContext) {
-				result, err := w.handler.Handle(r, r.Message())
-				atomic.AddInt64(&count, -1)
-				if !w.fixedRate {
-					atomic.AddInt64(&lastBatch, 1)
+	// Handle messages
+	//
+	// Batch mode deliberately sits outside the semaphore and the AIMD
+	// controller below: batcher.run processes one batch at a time on its own
+	// goroutine, so there's no per-message concurrency for sem to bound, and
+	// it never records to lastBatch/lastErrors/latency, so the controller
+	// would have no data to act on; the throughput goroutine below is not
+	// started for it at all. Sizing a batch worker's throughput is done via
+	// maxBatchSize/maxBatchWait on WithBatchHandler instead.
+	if w.batchHandler != nil {
+		b := newBatcher(w.batchConfig, w.batchHandler, w.name, w.panicPolicy, results, panics)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.run(attemptCtx, messages)
+		}()
+	} else {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-attemptCtx.Done():
+					return
+				case message, ok := <-messages:
+					if !ok {
+						return
+					}
+					if err := sem.acquire(attemptCtx); err != nil {
+						return
+					}
+					wg.Add(1)
+
+					go func(r *requestContext) {
+						defer wg.Done()
+						start := time.Now()
+						result, err := func() (result *v1.RawMessage, err error) {
+							defer func() {
+								if rec := recover(); rec != nil {
+									metrics.WorkerPanicsCounter.WithLabelValues(w.name, r.Source()).Inc()
+									err = panicToError(rec)
+								}
+							}()
+							return w.handler.Handle(r, r.Message())
+						}()
+						sem.release()
+						if !w.fixedRate {
+							atomic.AddInt64(&lastBatch, 1)
+							if err != nil {
+								atomic.AddInt64(&lastErrors, 1)
+							}
+							latency.record(time.Since(start))
+						}
+						results <- r.WithResult(result, err)
+						if _, isPanic := err.(*panicError); isPanic && w.panicPolicy != PanicPolicyContinue {
+							select {
+							case panics <- err:
+							default:
+							}
+						}
+					}(message)
 				}
-				results <- r.WithResult(result, err)
-			}(message)
-		}
-	}()
+			}
+		}()
+	}
 
-	// Handle throughput
-	if !w.fixedRate {
+	// Handle throughput. Skipped for batch mode, which the comment above
+	// explains never feeds this controller any data to act on.
+	if !w.fixedRate && w.batchHandler == nil {
 		go func() {
-			var prev int64
+			controller := w.concurrencyController
+			if controller == nil {
+				controller = &concurrency.AIMD{}
+			}
+			const latencyEWMAAlpha = 0.3
+			var latencyEWMA float64
+			var hasLatencyEWMA bool
+			var lastReason string
 			timer := time.NewTimer(w.dynamicRateBatchWindow)
-			shouldUpscale := true
-			logger.Debug().Int64("concurrency", maxItems).Msg("Using dynamic concurrency")
+			w.loggerFor(ctx, "").Debug().Int64("concurrency", sem.Limit()).Msg("Using dynamic concurrency")
 			for {
 				timer.Reset(w.dynamicRateBatchWindow)
 
 				select {
-				case <-ctx.Done():
+				case <-attemptCtx.Done():
 					return
 				case <-timer.C:
 				}
 
-				curr := atomic.SwapInt64(&lastBatch, 0)
-				batchSizeGauge.Set(float64(curr))
+				processed := atomic.SwapInt64(&lastBatch, 0)
+				errored := atomic.SwapInt64(&lastErrors, 0)
+				batchSizeGauge.Set(float64(processed))
 
-				if curr == 0 {
+				if processed == 0 {
 					continue
 				}
-				if curr < prev {
-					shouldUpscale = !shouldUpscale
+
+				p95 := latency.p95()
+				if !hasLatencyEWMA {
+					latencyEWMA = float64(p95)
+					hasLatencyEWMA = true
+				} else {
+					latencyEWMA = latencyEWMAAlpha*float64(p95) + (1-latencyEWMAAlpha)*latencyEWMA
 				}
-				if shouldUpscale {
-					atomic.AddInt64(&maxItems, 1)
-				} else if maxItems > minConcurrency {
-					atomic.AddInt64(&maxItems, -1)
+				latencyEWMAGauge.Set(latencyEWMA / float64(time.Second))
+
+				decision := controller.Next(concurrency.Stats{
+					Current:    sem.Limit(),
+					Min:        minConcurrency,
+					Processed:  processed,
+					ErrorRatio: float64(errored) / float64(processed),
+					P95Latency: p95,
+				})
+
+				sem.setLimit(decision.Concurrency)
+				maxConcurrencyGauge.Set(float64(decision.Concurrency))
+				if lastReason != "" && lastReason != decision.Reason {
+					decisionReasonGauge.WithLabelValues(w.name, lastReason).Set(0)
 				}
-				maxConcurrencyGauge.Set(float64(maxItems))
+				decisionReasonGauge.WithLabelValues(w.name, decision.Reason).Set(1)
+				lastReason = decision.Reason
 
-				prev = curr
-				logger.Debug().Int64("concurrency", maxItems).Float64("rate", float64(curr)/w.dynamicRateBatchWindow.Seconds()).Msg("tuning concurrency")
+				w.loggerFor(ctx, "").Debug().Int64("concurrency", decision.Concurrency).Str("reason", decision.Reason).
+					Float64("rate", float64(processed)/w.dynamicRateBatchWindow.Seconds()).Msg("tuning concurrency")
 			}
 		}()
 	}
-	done := make(chan error)
-	defer close(done)
+	reassembly := newReassemblyBuffer(w.chunkConfig, w.errorSource)
+	if w.chunkConfig.reassemblyTTL > 0 {
+		go func() {
+			ticker := time.NewTicker(w.chunkConfig.reassemblyTTL)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-attemptCtx.Done():
+					return
+				case <-ticker.C:
+					reassembly.evictExpired(ctx)
+				}
+			}
+		}()
+	}
+
+	done := make(chan error, 1)
 	for _, s := range w.sources {
+		wg.Add(1)
 		go func(s *v1.Source) {
-			logger.Info().Str("source", s.Name).Msg("Start reading from source")
+			defer wg.Done()
+			w.loggerFor(ctx, s.Name).Info().Msg("Start reading from source")
 			consumer := s.CreateConsumer()
-			err := consumer.Iter(ctx, v1.NextMessage(func(m v1.Message) {
-				messages <- createRequestContext(ctx, s.Name, m)
+			err := consumer.Iter(attemptCtx, v1.NextMessage(func(m v1.Message) {
+				if complete, ok := reassembly.offer(m); ok {
+					select {
+					case messages <- createRequestContext(withCorrelationID(ctx), s.Name, complete):
+					case <-attemptCtx.Done():
+					}
+				}
 			}))
 			if err != nil {
-				done <- err
+				select {
+				case done <- err:
+				default:
+				}
 			}
 		}(s)
 	}
 	select {
 	case err := <-done:
 		return err
+	case err := <-panics:
+		return err
 	case <-ctx.Done():
 		return nil
 	}
 }
 
 func (w *Worker) Start(ctx context.Context) error {
-	logger.Info().Msg("Starting pipe")
+	w.loggerFor(ctx, "").Info().Msg("Starting pipe")
 	messages := make(chan *requestContext, w.minConcurrency)
-	defer close(messages)
 	results := make(chan *requestContext, w.minConcurrency)
-	defer close(results)
-	done := make(chan error)
+
+	var closeMessagesOnce, closeResultsOnce sync.Once
+	closeMessages := func() { closeMessagesOnce.Do(func() { close(messages) }) }
+	closeResults := func() { closeResultsOnce.Do(func() { close(results) }) }
 
 	innerContext, cancel := context.WithCancel(ctx)
+	stopped := make(chan struct{})
+	w.mu.Lock()
+	w.cancel = cancel
+	w.stopped = stopped
+	w.mu.Unlock()
+	defer close(stopped)
 	defer cancel()
 
+	readDone := make(chan error, 1)
+	resultDone := make(chan error, 1)
+
 	go func() {
-		done <- w.readMessages(innerContext, messages, results)
+		err := w.superviseLoop(innerContext, "readMessages", func() error {
+			return w.readMessages(innerContext, messages, results)
+		})
+		// readMessages only returns once it has confirmed (via its own
+		// internal wait) that nothing can still be sending on messages or
+		// results. errDrainTimeout means that confirmation timed out, so the
+		// channels are leaked here rather than closed out from under a
+		// goroutine that may still be running.
+		if !errors.Is(err, errDrainTimeout) {
+			closeMessages()
+			closeResults()
+		}
+		readDone <- err
 	}()
 
 	go func() {
-		done <- w.handleResults(innerContext, results)
+		resultDone <- w.superviseLoop(innerContext, "handleResults", func() error {
+			return w.handleResults(innerContext, results)
+		})
 	}()
 
 	select {
 	case <-ctx.Done():
+		return w.drain(cancel, readDone, resultDone)
+	case err := <-readDone:
+		cancel()
+		resultErr := <-resultDone
+		return errors.Join(err, resultErr)
+	case err := <-resultDone:
+		cancel()
+		readErr := <-readDone
+		return errors.Join(err, readErr)
+	}
+}
+
+// drain implements the graceful-shutdown protocol: stop pulling from
+// sources (by cancelling the worker's inner context, which readMessages
+// treats as "finish in-flight work, then stop"), then wait for readMessages
+// and handleResults to finish, each under its own timeout so one phase
+// running long doesn't shorten the other's budget. Closing messages and
+// results happens back in Start, at the single point where each channel's
+// senders are known to be done - drain itself never closes either.
+func (w *Worker) drain(cancel context.CancelFunc, readDone, resultDone <-chan error) error {
+	cancel()
+
+	timeout := w.shutdownTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	var errs []error
+
+	readTimer := time.NewTimer(timeout)
+	select {
+	case err := <-readDone:
+		readTimer.Stop()
+		errs = append(errs, err)
+	case <-readTimer.C:
+		errs = append(errs, fmt.Errorf("pipe: timed out after %s draining in-flight messages", timeout))
+	}
+
+	resultTimer := time.NewTimer(timeout)
+	select {
+	case err := <-resultDone:
+		resultTimer.Stop()
+		errs = append(errs, err)
+	case <-resultTimer.C:
+		errs = append(errs, fmt.Errorf("pipe: timed out after %s flushing results", timeout))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Stop triggers the same graceful-shutdown protocol as cancelling the
+// context passed to Start, for callers that want to stop a worker without
+// owning that context. It returns once Start has finished draining, or ctx
+// is done, whichever comes first.
+func (w *Worker) Stop(ctx context.Context) error {
+	w.mu.Lock()
+	cancel := w.cancel
+	stopped := w.stopped
+	w.mu.Unlock()
+	if cancel == nil {
 		return nil
-	case err := <-done:
-		return err
+	}
+	cancel()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// superviseLoop runs fn until it returns a non-panic error, ctx is done, or
+// (when the worker's PanicPolicy is PanicPolicyStopWorker) it panicked and
+// is restarted with exponential backoff. A panic under PanicPolicyStopPipe
+// or PanicPolicyContinue is simply returned, which in turn stops Start.
+func (w *Worker) superviseLoop(ctx context.Context, name string, fn func() error) error {
+	backoff := time.Second
+	const maxBackoff = 30 * time.Second
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		var panicErr *panicError
+		if !errors.As(err, &panicErr) || w.panicPolicy != PanicPolicyStopWorker {
+			return err
+		}
+
+		w.loggerFor(ctx, "").Error().Err(err).Str("loop", name).Dur("backoff", backoff).Msg("Restarting worker loop after panic")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
 	}
 }
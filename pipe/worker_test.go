@@ -0,0 +1,100 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func newTestWorker(policy PanicPolicy) *Worker {
+	l := zerolog.Nop()
+	return &Worker{panicPolicy: policy, logger: &l}
+}
+
+func TestSuperviseLoop(t *testing.T) {
+	t.Run("ReturnsNilWithoutRestartingOnSuccess", func(t *testing.T) {
+		w := newTestWorker(PanicPolicyStopWorker)
+		calls := 0
+		err := w.superviseLoop(context.Background(), "loop", func() error {
+			calls++
+			return nil
+		})
+		if err != nil || calls != 1 {
+			t.Fatalf("expected a single successful call and a nil error, got err=%v calls=%d", err, calls)
+		}
+	})
+
+	t.Run("ReturnsNonPanicErrorWithoutRestarting", func(t *testing.T) {
+		w := newTestWorker(PanicPolicyStopWorker)
+		wantErr := errors.New("boom")
+		calls := 0
+		err := w.superviseLoop(context.Background(), "loop", func() error {
+			calls++
+			return wantErr
+		})
+		if !errors.Is(err, wantErr) || calls != 1 {
+			t.Fatalf("expected the plain error back after a single call, got err=%v calls=%d", err, calls)
+		}
+	})
+
+	t.Run("ReturnsPanicErrorImmediatelyWhenPolicyIsNotStopWorker", func(t *testing.T) {
+		w := newTestWorker(PanicPolicyContinue)
+		calls := 0
+		err := w.superviseLoop(context.Background(), "loop", func() error {
+			calls++
+			return panicToError("boom")
+		})
+		var panicErr *panicError
+		if !errors.As(err, &panicErr) || calls != 1 {
+			t.Fatalf("expected the panic error back without a restart, got err=%v calls=%d", err, calls)
+		}
+	})
+
+	t.Run("RestartsOnPanicUnderStopWorker", func(t *testing.T) {
+		w := newTestWorker(PanicPolicyStopWorker)
+		calls := 0
+		err := w.superviseLoop(context.Background(), "loop", func() error {
+			calls++
+			if calls == 1 {
+				return panicToError("boom")
+			}
+			return nil
+		})
+		if err != nil || calls != 2 {
+			t.Fatalf("expected a restart after the panic followed by success, got err=%v calls=%d", err, calls)
+		}
+	})
+
+	t.Run("RestartsOnPanicJoinedWithDrainTimeout", func(t *testing.T) {
+		// A slow drain wraps the panic as errors.Join(panicErr, errDrainTimeout);
+		// superviseLoop must still detect the panic via errors.As and restart.
+		w := newTestWorker(PanicPolicyStopWorker)
+		calls := 0
+		err := w.superviseLoop(context.Background(), "loop", func() error {
+			calls++
+			if calls == 1 {
+				return errors.Join(panicToError("boom"), errDrainTimeout)
+			}
+			return nil
+		})
+		if err != nil || calls != 2 {
+			t.Fatalf("a panic joined with errDrainTimeout must still trigger a restart, got err=%v calls=%d", err, calls)
+		}
+	})
+
+	t.Run("StopsWaitingOnRestartWhenCtxIsDone", func(t *testing.T) {
+		w := newTestWorker(PanicPolicyStopWorker)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		calls := 0
+		err := w.superviseLoop(ctx, "loop", func() error {
+			calls++
+			return panicToError("boom")
+		})
+		if err != nil || calls != 1 {
+			t.Fatalf("expected superviseLoop to give up once ctx is done instead of backing off, got err=%v calls=%d", err, calls)
+		}
+	})
+}